@@ -65,6 +65,10 @@ type BaseEndpointInfo struct {
 	// zoneHints represent the zone hints for the endpoint. This is based on
 	// endpoint.hints.forZones[*].name in the EndpointSlice API.
 	zoneHints sets.Set[string]
+
+	// nodeHints represent the node hints for the endpoint. This is based on
+	// endpoint.hints.forNodes[*].name in the EndpointSlice API.
+	nodeHints sets.Set[string]
 }
 
 var _ Endpoint = &BaseEndpointInfo{}
@@ -111,7 +115,12 @@ func (info *BaseEndpointInfo) ZoneHints() sets.Set[string] {
 	return info.zoneHints
 }
 
-func newBaseEndpointInfo(ip string, port int, isLocal, ready, serving, terminating bool, zoneHints sets.Set[string]) *BaseEndpointInfo {
+// NodeHints returns the node hint for the endpoint.
+func (info *BaseEndpointInfo) NodeHints() sets.Set[string] {
+	return info.nodeHints
+}
+
+func newBaseEndpointInfo(ip string, port int, isLocal, ready, serving, terminating bool, zoneHints, nodeHints sets.Set[string]) *BaseEndpointInfo {
 	return &BaseEndpointInfo{
 		ip:          ip,
 		port:        port,
@@ -121,6 +130,7 @@ func newBaseEndpointInfo(ip string, port int, isLocal, ready, serving, terminati
 		serving:     serving,
 		terminating: terminating,
 		zoneHints:   zoneHints,
+		nodeHints:   nodeHints,
 	}
 }
 
@@ -130,6 +140,23 @@ type makeEndpointFunc func(info *BaseEndpointInfo, svcPortName *ServicePortName)
 // EndpointsMap's but just use the changes for any Proxier specific cleanup.
 type processEndpointsMapChangeFunc func(oldEndpointsMap, newEndpointsMap EndpointsMap)
 
+// EndpointsEventHandler can be registered with EndpointsChangeTracker.AddEventHandler
+// to be notified, out-of-band from EndpointsMap.Update, as endpoint changes are applied.
+// Implementations must not call back into the EndpointsChangeTracker (e.g. AddEventHandler
+// or EndpointsMap.Update) from within a callback: callbacks run with the tracker's eventLock
+// held, so re-acquiring it deadlocks.
+type EndpointsEventHandler interface {
+	// OnEndpointsAdd is called whenever creation of a new endpoint object for
+	// svcPortName is observed.
+	OnEndpointsAdd(svcPortName ServicePortName, prev, cur []Endpoint)
+	// OnEndpointsUpdate is called whenever modification of an existing endpoint
+	// object for svcPortName is observed.
+	OnEndpointsUpdate(svcPortName ServicePortName, prev, cur []Endpoint)
+	// OnEndpointsDelete is called whenever deletion of an existing endpoint
+	// object for svcPortName is observed.
+	OnEndpointsDelete(svcPortName ServicePortName, prev, cur []Endpoint)
+}
+
 // EndpointsChangeTracker carries state about uncommitted changes to an arbitrary number of
 // Endpoints, keyed by their namespace and name.
 type EndpointsChangeTracker struct {
@@ -147,6 +174,14 @@ type EndpointsChangeTracker struct {
 	// This is specially problematic on restarts, because we process all the endpoints that may have been
 	// created hours or days before.
 	trackerStartTime time.Time
+
+	// eventLock protects eventHandlers. It is a dedicated lock, separate from
+	// lock, so that dispatching to handlers from within Update can never
+	// re-enter and deadlock against a concurrent EndpointSliceUpdate call.
+	eventLock sync.Mutex
+	// eventHandlers are notified, in registration order, of endpoint changes
+	// as they are applied by Update.
+	eventHandlers []EndpointsEventHandler
 }
 
 // NewEndpointsChangeTracker initializes an EndpointsChangeTracker
@@ -205,6 +240,63 @@ func (ect *EndpointsChangeTracker) EndpointSliceUpdate(endpointSlice *discovery.
 	return changeNeeded
 }
 
+// AddEventHandler registers an EndpointsEventHandler to be notified of
+// endpoint changes as they are applied by EndpointsMap.Update. Handlers are
+// invoked synchronously, under eventLock, so a handler must not call back
+// into the tracker (e.g. AddEventHandler, or Update while changes are
+// pending) from within a callback, or the process will deadlock.
+func (ect *EndpointsChangeTracker) AddEventHandler(handler EndpointsEventHandler) {
+	ect.eventLock.Lock()
+	defer ect.eventLock.Unlock()
+
+	ect.eventHandlers = append(ect.eventHandlers, handler)
+}
+
+// notifyEventHandlers diffs the previous and current per-service endpoint
+// lists for a single change and fans the result out to every registered
+// EndpointsEventHandler.
+func (ect *EndpointsChangeTracker) notifyEventHandlers(previous, current EndpointsMap) {
+	ect.eventLock.Lock()
+	defer ect.eventLock.Unlock()
+
+	if len(ect.eventHandlers) == 0 {
+		return
+	}
+
+	for svcPortName, curEps := range current {
+		prevEps, existed := previous[svcPortName]
+		for _, handler := range ect.eventHandlers {
+			if existed {
+				handler.OnEndpointsUpdate(svcPortName, prevEps, curEps)
+			} else {
+				handler.OnEndpointsAdd(svcPortName, nil, curEps)
+			}
+		}
+	}
+	for svcPortName, prevEps := range previous {
+		if _, stillExists := current[svcPortName]; stillExists {
+			continue
+		}
+		for _, handler := range ect.eventHandlers {
+			handler.OnEndpointsDelete(svcPortName, prevEps, nil)
+		}
+	}
+}
+
+// PendingChanges returns the set of service NamespacedNames whose endpoint
+// slices have been mutated since the last call to EndpointsMap.Update, without
+// consuming or clearing those changes. Unlike checkoutChanges, this is safe to
+// call repeatedly and is intended for proxier implementations that need to
+// know which services are dirty before they call Update, e.g. to plan a
+// partial sync, or to reconcile against a slice change that races with an
+// in-progress sync.
+func (ect *EndpointsChangeTracker) PendingChanges() sets.Set[types.NamespacedName] {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+
+	return ect.endpointSliceCache.pendingChanges()
+}
+
 // checkoutChanges returns a map of pending endpointsChanges and marks them as
 // applied.
 func (ect *EndpointsChangeTracker) checkoutChanges() map[types.NamespacedName]*endpointsChange {
@@ -266,15 +358,30 @@ type UpdateEndpointsMapResult struct {
 	// endpoints since the last Update.
 	UpdatedServices sets.Set[types.NamespacedName]
 
-	// DeletedUDPEndpoints identifies UDP endpoints that have just been deleted.
-	// Existing conntrack NAT entries pointing to these endpoints must be deleted to
-	// ensure that no further traffic for the Service gets delivered to them.
+	// ConntrackCleanupEndpoints identifies endpoints (of a connectionless protocol,
+	// i.e. UDP or SCTP) that have just been deleted, or that have transitioned out
+	// of the Serving state, or whose Ready state changed (in either direction)
+	// while remaining Serving. Existing conntrack NAT entries pointing at these endpoints
+	// must be deleted, both to stop delivering traffic to endpoints that can no
+	// longer accept it, and to allow traffic pinned to a still-serving-but-no-longer-
+	// ready endpoint to be re-balanced across the remaining endpoints.
+	ConntrackCleanupEndpoints []ServiceEndpoint
+
+	// DeletedUDPEndpoints is a deprecated alias for ConntrackCleanupEndpoints.
+	//
+	// Deprecated: use ConntrackCleanupEndpoints instead.
 	DeletedUDPEndpoints []ServiceEndpoint
 
-	// NewlyActiveUDPServices identifies UDP Services that have just gone from 0 to
-	// non-0 endpoints. Existing conntrack entries caching the fact that these
-	// services are black holes must be deleted to ensure that traffic can immediately
-	// begin flowing to the new endpoints.
+	// ConntrackCleanupServices identifies Services (of a connectionless protocol,
+	// i.e. UDP or SCTP) that have just gone from 0 to non-0 serving endpoints.
+	// Existing conntrack entries caching the fact that these services are black
+	// holes must be deleted to ensure that traffic can immediately begin flowing
+	// to the new endpoints.
+	ConntrackCleanupServices []ServicePortName
+
+	// NewlyActiveUDPServices is a deprecated alias for ConntrackCleanupServices.
+	//
+	// Deprecated: use ConntrackCleanupServices instead.
 	NewlyActiveUDPServices []ServicePortName
 
 	// List of the trigger times for all endpoints objects that changed. It's used to export the
@@ -291,10 +398,10 @@ type EndpointsMap map[ServicePortName][]Endpoint
 // changes map.
 func (em EndpointsMap) Update(ect *EndpointsChangeTracker) UpdateEndpointsMapResult {
 	result := UpdateEndpointsMapResult{
-		UpdatedServices:        sets.New[types.NamespacedName](),
-		DeletedUDPEndpoints:    make([]ServiceEndpoint, 0),
-		NewlyActiveUDPServices: make([]ServicePortName, 0),
-		LastChangeTriggerTimes: make(map[types.NamespacedName][]time.Time),
+		UpdatedServices:           sets.New[types.NamespacedName](),
+		ConntrackCleanupEndpoints: make([]ServiceEndpoint, 0),
+		ConntrackCleanupServices:  make([]ServicePortName, 0),
+		LastChangeTriggerTimes:    make(map[types.NamespacedName][]time.Time),
 	}
 	if ect == nil {
 		return result
@@ -305,14 +412,18 @@ func (em EndpointsMap) Update(ect *EndpointsChangeTracker) UpdateEndpointsMapRes
 		if ect.processEndpointsMapChange != nil {
 			ect.processEndpointsMapChange(change.previous, change.current)
 		}
+		ect.notifyEventHandlers(change.previous, change.current)
 		result.UpdatedServices.Insert(nn)
 
 		em.unmerge(change.previous)
 		em.merge(change.current)
-		detectStaleConntrackEntries(change.previous, change.current, &result.DeletedUDPEndpoints, &result.NewlyActiveUDPServices)
+		detectStaleConntrackEntries(change.previous, change.current, &result.ConntrackCleanupEndpoints, &result.ConntrackCleanupServices)
 	}
 	ect.checkoutTriggerTimes(&result.LastChangeTriggerTimes)
 
+	result.DeletedUDPEndpoints = result.ConntrackCleanupEndpoints
+	result.NewlyActiveUDPServices = result.ConntrackCleanupServices
+
 	return result
 }
 
@@ -330,14 +441,14 @@ func (em EndpointsMap) unmerge(other EndpointsMap) {
 	}
 }
 
-// getLocalEndpointIPs returns endpoints IPs if given endpoint is local - local means the endpoint is running in same host as kube-proxy.
-func (em EndpointsMap) getLocalReadyEndpointIPs() map[types.NamespacedName]sets.Set[string] {
+// getLocalEndpointIPs returns, for each service, the set of distinct local
+// endpoint IPs for which include returns true. "Local" means the endpoint is
+// running on the same host as kube-proxy.
+func (em EndpointsMap) getLocalEndpointIPs(include func(ep Endpoint) bool) map[types.NamespacedName]sets.Set[string] {
 	localIPs := make(map[types.NamespacedName]sets.Set[string])
 	for svcPortName, epList := range em {
 		for _, ep := range epList {
-			// Only add ready endpoints for health checking. Terminating endpoints may still serve traffic
-			// but the health check signal should fail if there are only terminating endpoints on a node.
-			if !ep.IsReady() {
+			if !include(ep) {
 				continue
 			}
 
@@ -364,22 +475,112 @@ func (em EndpointsMap) LocalReadyEndpoints() map[types.NamespacedName]int {
 	// consider a Service pointing to 10.0.0.1:80 and 10.0.0.1:443 to have 1 endpoint,
 	// not 2.)
 
+	// Only count ready endpoints for health checking. Terminating endpoints may
+	// still serve traffic but the health check signal should fail if there are
+	// only terminating endpoints on a node.
+	eps := make(map[types.NamespacedName]int)
+	localIPs := em.getLocalEndpointIPs(Endpoint.IsReady)
+	for nsn, ips := range localIPs {
+		eps[nsn] = len(ips)
+	}
+	return eps
+}
+
+// LocalServingEndpoints returns a map of Service names to the number of local
+// serving endpoints for that service, regardless of whether those endpoints
+// are also terminating. Unlike LocalReadyEndpoints, this lets a health check
+// server keep reporting a positive local-endpoint count during a graceful
+// termination window instead of flipping to unhealthy as soon as the last
+// non-terminating endpoint goes away.
+func (em EndpointsMap) LocalServingEndpoints() map[types.NamespacedName]int {
 	eps := make(map[types.NamespacedName]int)
-	localIPs := em.getLocalReadyEndpointIPs()
+	localIPs := em.getLocalEndpointIPs(Endpoint.IsServing)
 	for nsn, ips := range localIPs {
 		eps[nsn] = len(ips)
 	}
 	return eps
 }
 
+// LocalTerminatingEndpoints returns a map of Service names to the number of
+// local endpoints that are terminating but still serving traffic for that
+// service.
+func (em EndpointsMap) LocalTerminatingEndpoints() map[types.NamespacedName]int {
+	eps := make(map[types.NamespacedName]int)
+	localIPs := em.getLocalEndpointIPs(func(ep Endpoint) bool {
+		return ep.IsTerminating() && ep.IsServing()
+	})
+	for nsn, ips := range localIPs {
+		eps[nsn] = len(ips)
+	}
+	return eps
+}
+
+// EndpointCounts holds, for a single service, the number of endpoints in
+// each readiness state, along with how many of its ready endpoints are local
+// to this node.
+type EndpointCounts struct {
+	// Ready is the number of endpoints that are ready and not terminating.
+	Ready int
+	// Serving is the number of endpoints that are ready, regardless of
+	// whether they are also terminating.
+	Serving int
+	// Terminating is the number of endpoints that are terminating.
+	Terminating int
+	// Local is the number of distinct IPs among the Ready endpoints that are
+	// local to this node (unlike Ready, an IP behind several ports counts once).
+	Local int
+}
+
+// AllEndpointCounts returns, for every service, the Ready/Serving/Terminating
+// endpoint counts, plus the number of distinct local IPs among its Ready
+// endpoints. Local is deduped by IP the same way LocalReadyEndpoints is, so a
+// multi-port Service pointing at one local pod IP counts as one local
+// endpoint rather than one per port. This lets callers such as the
+// healthcheck server and node-proxy health endpoint publish a richer signal
+// than a single ready count without walking the map more than twice.
+func (em EndpointsMap) AllEndpointCounts() map[types.NamespacedName]EndpointCounts {
+	counts := make(map[types.NamespacedName]EndpointCounts)
+	for svcPortName, epList := range em {
+		nsn := svcPortName.NamespacedName
+		c := counts[nsn]
+		for _, ep := range epList {
+			if ep.IsReady() {
+				c.Ready++
+			}
+			if ep.IsServing() {
+				c.Serving++
+			}
+			if ep.IsTerminating() {
+				c.Terminating++
+			}
+		}
+		counts[nsn] = c
+	}
+
+	for nsn, ips := range em.getLocalEndpointIPs(Endpoint.IsReady) {
+		c := counts[nsn]
+		c.Local = len(ips)
+		counts[nsn] = c
+	}
+
+	return counts
+}
+
+// conntrackCleanupProtocols are the protocols for which conntrack entries can
+// go stale in ways the protocol itself won't detect and recover from, because
+// the protocol is connectionless from conntrack's point of view.
+var conntrackCleanupProtocols = sets.New(v1.ProtocolUDP, v1.ProtocolSCTP)
+
 // detectStaleConntrackEntries detects services that may be associated with stale conntrack entries.
-// (See UpdateEndpointsMapResult.DeletedUDPEndpoints and .NewlyActiveUDPServices.)
-func detectStaleConntrackEntries(oldEndpointsMap, newEndpointsMap EndpointsMap, deletedUDPEndpoints *[]ServiceEndpoint, newlyActiveUDPServices *[]ServicePortName) {
-	// Find the UDP endpoints that we were sending traffic to in oldEndpointsMap, but
-	// are no longer sending to newEndpointsMap. The proxier should make sure that
-	// conntrack does not accidentally route any new connections to them.
+// (See UpdateEndpointsMapResult.ConntrackCleanupEndpoints and .ConntrackCleanupServices.)
+func detectStaleConntrackEntries(oldEndpointsMap, newEndpointsMap EndpointsMap, conntrackCleanupEndpoints *[]ServiceEndpoint, conntrackCleanupServices *[]ServicePortName) {
+	// Find the endpoints that we were sending traffic to in oldEndpointsMap, but
+	// are no longer sending to newEndpointsMap, or whose serving/readiness state
+	// changed in a way that stale traffic could still be pinned to them. The
+	// proxier should make sure that conntrack does not accidentally keep routing
+	// connections to them.
 	for svcPortName, epList := range oldEndpointsMap {
-		if svcPortName.Protocol != v1.ProtocolUDP {
+		if !conntrackCleanupProtocols.Has(svcPortName.Protocol) {
 			continue
 		}
 
@@ -390,30 +591,34 @@ func detectStaleConntrackEntries(oldEndpointsMap, newEndpointsMap EndpointsMap,
 				continue
 			}
 
-			deleted := true
-			// Check if the endpoint has changed, including if it went from
-			// serving to not serving. If it did change stale entries for the old
-			// endpoint have to be cleared.
+			stale := true
+			// Check if the endpoint is unchanged, including its Serving and
+			// Ready state. If it went from Serving to not-Serving, or stayed
+			// Serving but its Ready state changed (e.g. Ready to NotReady as it
+			// starts terminating), traffic that conntrack pinned to it should be
+			// re-balanced, so treat it as stale too.
 			for i := range newEndpointsMap[svcPortName] {
-				if newEndpointsMap[svcPortName][i].String() == ep.String() &&
-					newEndpointsMap[svcPortName][i].IsServing() == ep.IsServing() {
-					deleted = false
+				newEp := newEndpointsMap[svcPortName][i]
+				if newEp.String() == ep.String() &&
+					newEp.IsServing() == ep.IsServing() &&
+					newEp.IsReady() == ep.IsReady() {
+					stale = false
 					break
 				}
 			}
-			if deleted {
-				klog.V(4).InfoS("Deleted endpoint may have stale conntrack entries", "portName", svcPortName, "endpoint", ep)
-				*deletedUDPEndpoints = append(*deletedUDPEndpoints, ServiceEndpoint{Endpoint: ep.String(), ServicePortName: svcPortName})
+			if stale {
+				klog.V(4).InfoS("Stale endpoint may have stale conntrack entries", "portName", svcPortName, "endpoint", ep)
+				*conntrackCleanupEndpoints = append(*conntrackCleanupEndpoints, ServiceEndpoint{Endpoint: ep.String(), ServicePortName: svcPortName})
 			}
 		}
 	}
 
-	// Detect services that have gone from 0 to non-0 ready endpoints. If there were
-	// previously 0 endpoints, but someone tried to connect to it, then a conntrack
-	// entry may have been created blackholing traffic to that IP, which should be
-	// deleted now.
+	// Detect services that have gone from 0 to non-0 serving endpoints. If there
+	// were previously 0 endpoints, but someone tried to connect to it, then a
+	// conntrack entry may have been created blackholing traffic to that IP, which
+	// should be deleted now.
 	for svcPortName, epList := range newEndpointsMap {
-		if svcPortName.Protocol != v1.ProtocolUDP {
+		if !conntrackCleanupProtocols.Has(svcPortName.Protocol) {
 			continue
 		}
 
@@ -432,7 +637,7 @@ func detectStaleConntrackEntries(oldEndpointsMap, newEndpointsMap EndpointsMap,
 		}
 
 		if epServing > 0 && oldEpServing == 0 {
-			*newlyActiveUDPServices = append(*newlyActiveUDPServices, svcPortName)
+			*conntrackCleanupServices = append(*conntrackCleanupServices, svcPortName)
 		}
 	}
 }
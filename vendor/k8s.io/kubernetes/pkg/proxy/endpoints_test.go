@@ -0,0 +1,344 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// fakeEndpoint is a minimal Endpoint implementation for tests that only need
+// to control IsReady/IsServing/IsTerminating and identity.
+type fakeEndpoint struct {
+	ip          string
+	port        int
+	ready       bool
+	serving     bool
+	terminating bool
+}
+
+var _ Endpoint = &fakeEndpoint{}
+
+func (e *fakeEndpoint) String() string             { return fakeEndpointString(e.ip, e.port) }
+func (e *fakeEndpoint) IP() string                 { return e.ip }
+func (e *fakeEndpoint) Port() int                  { return e.port }
+func (e *fakeEndpoint) IsLocal() bool              { return false }
+func (e *fakeEndpoint) IsReady() bool              { return e.ready }
+func (e *fakeEndpoint) IsServing() bool            { return e.serving }
+func (e *fakeEndpoint) IsTerminating() bool        { return e.terminating }
+func (e *fakeEndpoint) ZoneHints() sets.Set[string] { return nil }
+func (e *fakeEndpoint) NodeHints() sets.Set[string] { return nil }
+
+func fakeEndpointString(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+func makeTestEndpointSlice(sliceName string, ready bool) *discovery.EndpointSlice {
+	port := int32(80)
+	portName := "p"
+	protocol := v1.ProtocolTCP
+	return &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sliceName,
+			Namespace: "ns",
+			Labels:    map[string]string{discovery.LabelServiceName: "svc"},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Ports: []discovery.EndpointPort{
+			{Name: &portName, Port: &port, Protocol: &protocol},
+		},
+		Endpoints: []discovery.Endpoint{
+			{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+			},
+		},
+	}
+}
+
+// TestPendingChangesMatchesUpdatedServices verifies the invariant that
+// PendingChanges(), observed just before a call to EndpointsMap.Update,
+// agrees with the UpdatedServices that Update() goes on to report, as long
+// as no further EndpointSliceUpdate calls race in between.
+func TestPendingChangesMatchesUpdatedServices(t *testing.T) {
+	ect := NewEndpointsChangeTracker("host1", nil, v1.IPv4Protocol, nil, nil)
+	em := EndpointsMap{}
+	nsn := types.NamespacedName{Namespace: "ns", Name: "svc"}
+
+	if pending := ect.PendingChanges(); pending.Len() != 0 {
+		t.Fatalf("PendingChanges() on a fresh tracker = %v, want empty", pending)
+	}
+
+	if !ect.EndpointSliceUpdate(makeTestEndpointSlice("svc-abcde", true), false) {
+		t.Fatalf("EndpointSliceUpdate() = false, want true for a new slice")
+	}
+
+	pending := ect.PendingChanges()
+	if !pending.Has(nsn) {
+		t.Fatalf("PendingChanges() = %v, want it to contain %v", pending, nsn)
+	}
+
+	result := em.Update(ect)
+	if !result.UpdatedServices.Equal(pending) {
+		t.Errorf("UpdatedServices from Update() = %v, want it to match the PendingChanges() observed before the call (%v)", result.UpdatedServices, pending)
+	}
+
+	if got := ect.PendingChanges(); got.Len() != 0 {
+		t.Errorf("PendingChanges() after Update() = %v, want empty", got)
+	}
+}
+
+// TestDetectStaleConntrackEntries covers the transition matrix that
+// detectStaleConntrackEntries is responsible for: deletion, a Serving
+// transition, and a Ready transition while remaining Serving, each for both
+// of the connectionless protocols (UDP and SCTP), plus a same-protocol
+// no-op and a non-connectionless protocol that must be ignored entirely.
+func TestDetectStaleConntrackEntries(t *testing.T) {
+	svcPortName := func(protocol v1.Protocol) ServicePortName {
+		return ServicePortName{
+			NamespacedName: types.NamespacedName{Namespace: "ns", Name: "svc"},
+			Port:           "p",
+			Protocol:       protocol,
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		protocol       v1.Protocol
+		oldEndpoints   []Endpoint
+		newEndpoints   []Endpoint
+		wantCleanupEPs []string
+		wantCleanupSvc bool
+	}{
+		{
+			name:     "UDP endpoint deleted",
+			protocol: v1.ProtocolUDP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.1", port: 80, ready: true, serving: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.1:80"},
+		},
+		{
+			name:     "SCTP endpoint deleted",
+			protocol: v1.ProtocolSCTP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.2", port: 80, ready: true, serving: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.2:80"},
+		},
+		{
+			name:     "UDP endpoint goes from serving to not serving",
+			protocol: v1.ProtocolUDP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.3", port: 80, ready: true, serving: true},
+			},
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.3", port: 80, ready: false, serving: false, terminating: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.3:80"},
+		},
+		{
+			name:     "SCTP endpoint goes from serving to not serving",
+			protocol: v1.ProtocolSCTP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.4", port: 80, ready: true, serving: true},
+			},
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.4", port: 80, ready: false, serving: false, terminating: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.4:80"},
+		},
+		{
+			name:     "UDP endpoint goes from ready to not-ready while remaining serving",
+			protocol: v1.ProtocolUDP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.5", port: 80, ready: true, serving: true},
+			},
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.5", port: 80, ready: false, serving: true, terminating: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.5:80"},
+		},
+		{
+			name:     "SCTP endpoint goes from ready to not-ready while remaining serving",
+			protocol: v1.ProtocolSCTP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.6", port: 80, ready: true, serving: true},
+			},
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.6", port: 80, ready: false, serving: true, terminating: true},
+			},
+			wantCleanupEPs: []string{"10.0.0.6:80"},
+		},
+		{
+			name:     "UDP endpoint unchanged",
+			protocol: v1.ProtocolUDP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.7", port: 80, ready: true, serving: true},
+			},
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.7", port: 80, ready: true, serving: true},
+			},
+		},
+		{
+			name:     "TCP endpoint deletion is ignored",
+			protocol: v1.ProtocolTCP,
+			oldEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.8", port: 80, ready: true, serving: true},
+			},
+		},
+		{
+			name:     "UDP service goes from 0 to non-0 serving endpoints",
+			protocol: v1.ProtocolUDP,
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.9", port: 80, ready: true, serving: true},
+			},
+			wantCleanupSvc: true,
+		},
+		{
+			name:     "SCTP service goes from 0 to non-0 serving endpoints",
+			protocol: v1.ProtocolSCTP,
+			newEndpoints: []Endpoint{
+				&fakeEndpoint{ip: "10.0.0.10", port: 80, ready: true, serving: true},
+			},
+			wantCleanupSvc: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spn := svcPortName(tc.protocol)
+			oldMap := EndpointsMap{}
+			if tc.oldEndpoints != nil {
+				oldMap[spn] = tc.oldEndpoints
+			}
+			newMap := EndpointsMap{}
+			if tc.newEndpoints != nil {
+				newMap[spn] = tc.newEndpoints
+			}
+
+			var gotEndpoints []ServiceEndpoint
+			var gotServices []ServicePortName
+			detectStaleConntrackEntries(oldMap, newMap, &gotEndpoints, &gotServices)
+
+			var gotEPStrings []string
+			for _, se := range gotEndpoints {
+				gotEPStrings = append(gotEPStrings, se.Endpoint)
+			}
+			if !reflect.DeepEqual(gotEPStrings, tc.wantCleanupEPs) {
+				t.Errorf("ConntrackCleanupEndpoints = %v, want %v", gotEPStrings, tc.wantCleanupEPs)
+			}
+
+			if gotSvc := len(gotServices) > 0; gotSvc != tc.wantCleanupSvc {
+				t.Errorf("ConntrackCleanupServices = %v, want non-empty=%v", gotServices, tc.wantCleanupSvc)
+			}
+		})
+	}
+}
+
+// TestAllEndpointCountsLocalDedupedByIP verifies that AllEndpointCounts'
+// Local field agrees with LocalReadyEndpoints for a multi-port Service
+// sharing a single local pod IP: both must count it once, not once per port.
+func TestAllEndpointCountsLocalDedupedByIP(t *testing.T) {
+	nsn := types.NamespacedName{Namespace: "ns", Name: "svc"}
+	httpPort := ServicePortName{NamespacedName: nsn, Port: "http", Protocol: v1.ProtocolTCP}
+	httpsPort := ServicePortName{NamespacedName: nsn, Port: "https", Protocol: v1.ProtocolTCP}
+
+	em := EndpointsMap{
+		httpPort: {
+			&BaseEndpointInfo{ip: "10.0.0.1", isLocal: true, ready: true, serving: true},
+		},
+		httpsPort: {
+			&BaseEndpointInfo{ip: "10.0.0.1", isLocal: true, ready: true, serving: true},
+		},
+	}
+
+	want := em.LocalReadyEndpoints()[nsn]
+	got := em.AllEndpointCounts()[nsn].Local
+	if got != want {
+		t.Errorf("AllEndpointCounts()[nsn].Local = %d, want %d to match LocalReadyEndpoints()[nsn]", got, want)
+	}
+	if got != 1 {
+		t.Errorf("AllEndpointCounts()[nsn].Local = %d, want 1 for a single IP behind two ports", got)
+	}
+}
+
+// recordingEventHandler is an EndpointsEventHandler that records every
+// OnEndpointsAdd/Update/Delete call it receives, in order, for assertions.
+type recordingEventHandler struct {
+	calls []string
+}
+
+func (h *recordingEventHandler) OnEndpointsAdd(svcPortName ServicePortName, prev, cur []Endpoint) {
+	h.calls = append(h.calls, "add")
+}
+
+func (h *recordingEventHandler) OnEndpointsUpdate(svcPortName ServicePortName, prev, cur []Endpoint) {
+	h.calls = append(h.calls, "update")
+}
+
+func (h *recordingEventHandler) OnEndpointsDelete(svcPortName ServicePortName, prev, cur []Endpoint) {
+	h.calls = append(h.calls, "delete")
+}
+
+var _ EndpointsEventHandler = &recordingEventHandler{}
+
+// TestAddEventHandler verifies that a registered EndpointsEventHandler is
+// notified of Add, Update and Delete transitions as EndpointsMap.Update
+// applies them, and that it has already fired by the time Update returns
+// (i.e. before checkoutTriggerTimes runs and the caller observes the result).
+func TestAddEventHandler(t *testing.T) {
+	ect := NewEndpointsChangeTracker("host1", nil, v1.IPv4Protocol, nil, nil)
+	handler := &recordingEventHandler{}
+	ect.AddEventHandler(handler)
+	em := EndpointsMap{}
+
+	// Add: a brand new slice for the service.
+	if !ect.EndpointSliceUpdate(makeTestEndpointSlice("svc-abcde", true), false) {
+		t.Fatalf("EndpointSliceUpdate() = false, want true for a new slice")
+	}
+	em.Update(ect)
+	if want := []string{"add"}; !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("calls after add = %v, want %v", handler.calls, want)
+	}
+
+	// Update: the same slice flips to not-ready.
+	if !ect.EndpointSliceUpdate(makeTestEndpointSlice("svc-abcde", false), false) {
+		t.Fatalf("EndpointSliceUpdate() = false, want true for an updated slice")
+	}
+	em.Update(ect)
+	if want := []string{"add", "update"}; !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("calls after update = %v, want %v", handler.calls, want)
+	}
+
+	// Delete: remove the slice entirely.
+	if !ect.EndpointSliceUpdate(makeTestEndpointSlice("svc-abcde", false), true) {
+		t.Fatalf("EndpointSliceUpdate() = false, want true for a removed slice")
+	}
+	em.Update(ect)
+	if want := []string{"add", "update", "delete"}; !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("calls after delete = %v, want %v", handler.calls, want)
+	}
+}
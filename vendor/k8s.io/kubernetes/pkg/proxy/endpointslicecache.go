@@ -0,0 +1,314 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/events"
+)
+
+// EndpointSliceCache is used as a cache of EndpointSlice information.
+type EndpointSliceCache struct {
+	// lock protects trackerByServiceName.
+	lock sync.Mutex
+
+	// trackerByServiceName is the basis of this cache. It contains endpoint
+	// slice trackers grouped by service name and keyed by the name of the
+	// EndpointSlice that contributed them. Endpoints can move between
+	// slices, so each slice is tracked individually and merged into an
+	// EndpointsMap when changes are checked out.
+	trackerByServiceName map[types.NamespacedName]*endpointSliceTracker
+
+	// makeEndpointInfo allows proxier implementations to inject customized
+	// information when processing an endpoint.
+	makeEndpointInfo makeEndpointFunc
+
+	// hostname is the host where kube-proxy is running.
+	hostname string
+
+	// ipFamily defines the IP family expected for this cache.
+	ipFamily v1.IPFamily
+
+	recorder events.EventRecorder
+}
+
+// endpointSliceTracker keeps track of EndpointSlices as they have been
+// applied (checked out) by a proxier, along with any pending EndpointSlices
+// that have been updated in this cache but not yet applied.
+type endpointSliceTracker struct {
+	applied endpointSliceInfoByName
+	pending endpointSliceInfoByName
+}
+
+func newEndpointSliceTracker() *endpointSliceTracker {
+	return &endpointSliceTracker{
+		applied: endpointSliceInfoByName{},
+		pending: endpointSliceInfoByName{},
+	}
+}
+
+// endpointSliceInfoByName groups endpointSliceInfo by the name of the
+// EndpointSlice it was built from.
+type endpointSliceInfoByName map[string]*endpointSliceInfo
+
+// endpointSliceInfo contains just the attributes kube-proxy cares about.
+// Used for caching. Intended to be used interchangeably with
+// discovery.EndpointSlice.
+type endpointSliceInfo struct {
+	Ports     []discovery.EndpointPort
+	Endpoints []*endpoint
+	Remove    bool
+}
+
+// endpoint contains just the attributes kube-proxy cares about. Used for
+// caching. Intended to be used interchangeably with discovery.Endpoint.
+type endpoint struct {
+	Addresses   []string
+	NodeName    *string
+	Zone        *string
+	ZoneHints   sets.Set[string]
+	NodeHints   sets.Set[string]
+	Ready       bool
+	Serving     bool
+	Terminating bool
+}
+
+// newEndpointSliceInfo converts endpointSlice into the endpointSliceInfo
+// representation cached by EndpointSliceCache. If remove is true, the
+// endpoints are not copied, since the slice is being withdrawn.
+func newEndpointSliceInfo(endpointSlice *discovery.EndpointSlice, remove bool) *endpointSliceInfo {
+	esInfo := &endpointSliceInfo{
+		Ports:     endpointSlice.Ports,
+		Endpoints: []*endpoint{},
+		Remove:    remove,
+	}
+
+	if !remove {
+		for _, ep := range endpointSlice.Endpoints {
+			epInfo := &endpoint{
+				Addresses:   ep.Addresses,
+				Ready:       ep.Conditions.Ready == nil || *ep.Conditions.Ready,
+				Serving:     ep.Conditions.Serving == nil || *ep.Conditions.Serving,
+				Terminating: ep.Conditions.Terminating != nil && *ep.Conditions.Terminating,
+				NodeName:    ep.NodeName,
+				Zone:        ep.Zone,
+			}
+
+			if ep.Hints != nil && len(ep.Hints.ForZones) > 0 {
+				epInfo.ZoneHints = sets.New[string]()
+				for _, forZone := range ep.Hints.ForZones {
+					epInfo.ZoneHints.Insert(forZone.Name)
+				}
+			}
+			if ep.Hints != nil && len(ep.Hints.ForNodes) > 0 {
+				epInfo.NodeHints = sets.New[string]()
+				for _, forNode := range ep.Hints.ForNodes {
+					epInfo.NodeHints.Insert(forNode.Name)
+				}
+			}
+
+			esInfo.Endpoints = append(esInfo.Endpoints, epInfo)
+		}
+	}
+
+	return esInfo
+}
+
+// NewEndpointSliceCache initializes an EndpointSliceCache.
+func NewEndpointSliceCache(hostname string, ipFamily v1.IPFamily, recorder events.EventRecorder, makeEndpointInfo makeEndpointFunc) *EndpointSliceCache {
+	if makeEndpointInfo == nil {
+		makeEndpointInfo = standardEndpointInfo
+	}
+	return &EndpointSliceCache{
+		trackerByServiceName: map[types.NamespacedName]*endpointSliceTracker{},
+		hostname:             hostname,
+		ipFamily:             ipFamily,
+		makeEndpointInfo:     makeEndpointInfo,
+		recorder:             recorder,
+	}
+}
+
+// standardEndpointInfo is the default makeEndpointFunc used when a proxier
+// doesn't need to inject its own EndpointInfo implementation.
+func standardEndpointInfo(info *BaseEndpointInfo, _ *ServicePortName) Endpoint {
+	return info
+}
+
+// updatePending updates a pending slice in the cache and returns true if the
+// update contains a change that needs to be synced.
+func (cache *EndpointSliceCache) updatePending(endpointSlice *discovery.EndpointSlice, remove bool) bool {
+	serviceKey, sliceKey, err := endpointSliceCacheKeys(endpointSlice)
+	if err != nil {
+		return false
+	}
+
+	esInfo := newEndpointSliceInfo(endpointSlice, remove)
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if _, ok := cache.trackerByServiceName[serviceKey]; !ok {
+		cache.trackerByServiceName[serviceKey] = newEndpointSliceTracker()
+	}
+
+	changed := cache.esInfoChanged(serviceKey, sliceKey, esInfo)
+	if changed {
+		cache.trackerByServiceName[serviceKey].pending[sliceKey] = esInfo
+	}
+
+	return changed
+}
+
+// esInfoChanged returns true if esInfo differs from whatever is already
+// cached (pending, or failing that, applied) for this slice, or if there is
+// nothing cached yet and esInfo isn't itself a no-op removal.
+func (cache *EndpointSliceCache) esInfoChanged(serviceKey types.NamespacedName, sliceKey string, esInfo *endpointSliceInfo) bool {
+	esTracker, ok := cache.trackerByServiceName[serviceKey]
+	if !ok {
+		return !esInfo.Remove
+	}
+
+	if pendingInfo, ok := esTracker.pending[sliceKey]; ok {
+		return !reflect.DeepEqual(esInfo, pendingInfo)
+	}
+	if appliedInfo, ok := esTracker.applied[sliceKey]; ok {
+		return !reflect.DeepEqual(esInfo, appliedInfo)
+	}
+
+	return !esInfo.Remove
+}
+
+// checkoutChanges returns a map of pending endpointsChanges and marks them as
+// applied.
+func (cache *EndpointSliceCache) checkoutChanges() map[types.NamespacedName]*endpointsChange {
+	changes := make(map[types.NamespacedName]*endpointsChange)
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for serviceNN, esTracker := range cache.trackerByServiceName {
+		if len(esTracker.pending) == 0 {
+			continue
+		}
+
+		change := &endpointsChange{
+			previous: cache.getEndpointsMap(serviceNN, esTracker.applied),
+		}
+
+		for name, sliceInfo := range esTracker.pending {
+			if sliceInfo.Remove {
+				delete(esTracker.applied, name)
+			} else {
+				esTracker.applied[name] = sliceInfo
+			}
+			delete(esTracker.pending, name)
+		}
+
+		change.current = cache.getEndpointsMap(serviceNN, esTracker.applied)
+		changes[serviceNN] = change
+
+		if len(esTracker.applied) == 0 {
+			delete(cache.trackerByServiceName, serviceNN)
+		}
+	}
+
+	return changes
+}
+
+// pendingChanges returns the set of service NamespacedNames that currently
+// have at least one pending (not yet applied) EndpointSlice update, without
+// consuming them. It is the read-only counterpart to checkoutChanges, and
+// backs EndpointsChangeTracker.PendingChanges.
+func (cache *EndpointSliceCache) pendingChanges() sets.Set[types.NamespacedName] {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	pending := sets.New[types.NamespacedName]()
+	for serviceNN, esTracker := range cache.trackerByServiceName {
+		if len(esTracker.pending) > 0 {
+			pending.Insert(serviceNN)
+		}
+	}
+	return pending
+}
+
+// getEndpointsMap builds the current EndpointsMap for a service from its
+// cached endpoint slice information, creating one Endpoint per distinct IP
+// address for each of the service's ports (an IP appearing in more than one
+// slice for the same port, e.g. during a slice rollover, is only counted
+// once).
+func (cache *EndpointSliceCache) getEndpointsMap(serviceNN types.NamespacedName, sliceInfoByName endpointSliceInfoByName) EndpointsMap {
+	endpointsMap := EndpointsMap{}
+	seenByPort := map[ServicePortName]sets.Set[string]{}
+
+	for _, sliceInfo := range sliceInfoByName {
+		for _, port := range sliceInfo.Ports {
+			if port.Name == nil || port.Port == nil {
+				continue
+			}
+			protocol := v1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			svcPortName := ServicePortName{
+				NamespacedName: serviceNN,
+				Port:           *port.Name,
+				Protocol:       protocol,
+			}
+
+			seen, ok := seenByPort[svcPortName]
+			if !ok {
+				seen = sets.New[string]()
+				seenByPort[svcPortName] = seen
+			}
+
+			for _, ep := range sliceInfo.Endpoints {
+				if len(ep.Addresses) == 0 || seen.Has(ep.Addresses[0]) {
+					continue
+				}
+				seen.Insert(ep.Addresses[0])
+
+				isLocal := cache.hostname != "" && ep.NodeName != nil && *ep.NodeName == cache.hostname
+				baseInfo := newBaseEndpointInfo(ep.Addresses[0], int(*port.Port), isLocal, ep.Ready, ep.Serving, ep.Terminating, ep.ZoneHints, ep.NodeHints)
+				endpointsMap[svcPortName] = append(endpointsMap[svcPortName], cache.makeEndpointInfo(baseInfo, &svcPortName))
+			}
+		}
+	}
+
+	return endpointsMap
+}
+
+// endpointSliceCacheKeys returns the keys to use for a given EndpointSlice in
+// the EndpointSliceCache, namely the NamespacedName of the Service the slice
+// belongs to, and the name of the slice itself.
+func endpointSliceCacheKeys(endpointSlice *discovery.EndpointSlice) (types.NamespacedName, string, error) {
+	var err error
+	serviceName, ok := endpointSlice.Labels[discovery.LabelServiceName]
+	if !ok || serviceName == "" {
+		err = fmt.Errorf("no %s label set on endpoint slice: %s", discovery.LabelServiceName, endpointSlice.Name)
+	} else if endpointSlice.Namespace == "" || endpointSlice.Name == "" {
+		err = fmt.Errorf("expected EndpointSlice name and namespace to be set: %v", endpointSlice)
+	}
+	return types.NamespacedName{Namespace: endpointSlice.Namespace, Name: serviceName}, endpointSlice.Name, err
+}